@@ -19,11 +19,82 @@ under the License.
 
 package consensus
 
-import pb "github.com/openblockchain/obc-peer/protos"
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/openblockchain/obc-peer/protos"
+)
 
 // Consenter is implemented by every consensus plugin package
 type Consenter interface {
 	RecvMsg(msg *pb.OpenchainMessage) error
+
+	// ValidateBlock checks that block is acceptable to this consensus
+	// implementation, including its header and any consensus-specific
+	// metadata or transaction content
+	ValidateBlock(block *pb.Block) error
+
+	// ValidateBlockHeader checks only that block's header (its link to the
+	// parent and any consensus-specific header fields) is well formed
+	ValidateBlockHeader(block *pb.Block) error
+
+	// ValidateBlockPubsub is the ValidateBlock check adapted to a pubsub
+	// message, for consensus implementations that relay blocks over a
+	// gossip topic rather than point-to-point messaging
+	ValidateBlockPubsub(msg *pubsub.Message) pubsub.ValidationResult
+
+	// MinerCreateBlock assembles a candidate block extending parent with
+	// txs, without committing it
+	MinerCreateBlock(ctx context.Context, parent *pb.Block, txs []*pb.Transaction) (*pb.Block, error)
+
+	// Weight reports this consensus implementation's notion of a chain's
+	// weight, used to pick a canonical chain among competing forks
+	Weight(chain []*pb.Block) (uint64, error)
+
+	// IsEpochBeyondCurr reports whether epoch lies beyond the epoch this
+	// consensus implementation currently considers current
+	IsEpochBeyondCurr(epoch uint64) bool
+}
+
+// ConsensusFactory constructs a Consenter bound to a particular CPI handle.
+// Consensus plugin packages register a ConsensusFactory under a name via
+// Register, typically from an init() function, so that the name can be
+// selected later from peer configuration
+type ConsensusFactory func(cpi CPI) Consenter
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]ConsensusFactory)
+)
+
+// Register makes factory available under name for later construction via
+// New. Register panics if name is already registered, mirroring the
+// database/sql driver registration pattern
+func Register(name string, factory ConsensusFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("consensus: Register called twice for name %q", name))
+	}
+	registry[name] = factory
+}
+
+// New constructs the consensus implementation registered under name, bound
+// to cpi
+func New(name string, cpi CPI) (Consenter, error) {
+	registryMutex.Lock()
+	factory, ok := registry[name]
+	registryMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("consensus: no implementation registered under name %q", name)
+	}
+
+	return factory(cpi), nil
 }
 
 // ReadOnlyLedger is used for interrogating the blockchain
@@ -31,12 +102,24 @@ type ReadOnlyLedger interface {
 	GetBlock(id uint64) (block *pb.Block, err error)
 	GetCurrentStateHash() (stateHash []byte, err error)
 	GetBlockchainSize() (uint64, error)
+
+	// GetTransactionProof returns a Merkle proof that the transaction at txIndex
+	// is included in the transaction tree rooted at the block's hash
+	GetTransactionProof(blockNumber uint64, txIndex int) (*pb.MerkleProof, error)
+
+	// GetStateProof returns a Merkle proof that key maps to the returned value in
+	// the state trie rooted at the block's StateHash, along with that value
+	GetStateProof(key []byte) (*pb.MerkleProof, []byte, error)
 }
 
 // UtilLedger contains additional useful utility functions for interrogating the blockchain
 type UtilLedger interface {
 	HashBlock(block *pb.Block) ([]byte, error)
 	VerifyBlockchain(start, finish uint64) (uint64, error)
+
+	// VerifyProof recomputes root by folding leaf up through proof's sibling
+	// hashes and reports whether the result matches root
+	VerifyProof(root []byte, proof *pb.MerkleProof, leaf []byte) (bool, error)
 }
 
 // WritableLedger is useful for updating the blockchain during state transfer
@@ -67,6 +150,49 @@ type RemoteLedgers interface {
 	GetRemoteBlocks(replicaId uint64, start, finish uint64) (<-chan *pb.SyncBlocks, error)
 	GetRemoteStateSnapshot(replicaId uint64) (<-chan *pb.SyncStateSnapshot, error)
 	GetRemoteStateDeltas(replicaId uint64, start, finish uint64) (<-chan *pb.SyncStateDeltas, error)
+
+	// GetStateSnapshotManifest describes a chunked, content-addressed state
+	// snapshot as of blockNumber: the Merkle root over its chunk hashes, the
+	// chunk count, the individual chunk hashes, and the block/state root the
+	// snapshot is expected to produce once every chunk has been applied
+	GetStateSnapshotManifest(replicaId uint64, blockNumber uint64) (*pb.SnapshotManifest, error)
+
+	// GetStateSnapshotChunk fetches a single chunk of the snapshot described
+	// by manifestRoot, previously obtained from GetStateSnapshotManifest
+	GetStateSnapshotChunk(replicaId uint64, manifestRoot []byte, chunkIndex uint32) ([]byte, error)
+}
+
+// EventFilter restricts which chaincode events a SubscribeChaincodeEvents
+// subscriber receives
+type EventFilter interface {
+	Allow(event *pb.ChaincodeEvent) bool
+}
+
+// QueryLedger provides indexed lookups over committed blocks and
+// transactions that ReadOnlyLedger cannot answer without a full chain scan.
+// Implementations populate these indexes incrementally as blocks and
+// transaction results are committed, backed by a KVStore so the index can
+// be kept in memory or persisted independently of the ledger itself
+type QueryLedger interface {
+	// GetTransactionByID returns the transaction with the given ID and the
+	// number of the block it was committed in
+	GetTransactionByID(txID string) (*pb.Transaction, uint64, error)
+
+	// GetBlockByHash returns the block whose hash is hash
+	GetBlockByHash(hash []byte) (*pb.Block, error)
+
+	// ListTransactionsByChaincode returns up to limit transactions invoking
+	// chaincodeID, in commit order, skipping the first start matches. A
+	// limit of zero means no limit.
+	ListTransactionsByChaincode(chaincodeID string, start, limit uint64) ([]*pb.Transaction, error)
+
+	// GetTransactionResults returns the TransactionResult recorded for txID
+	// when its batch was committed
+	GetTransactionResults(txID string) (*pb.TransactionResult, error)
+
+	// SubscribeChaincodeEvents streams ChaincodeEvents emitted by chaincodeID
+	// as they are committed, restricted to those filter allows
+	SubscribeChaincodeEvents(chaincodeID string, filter EventFilter) (<-chan *pb.ChaincodeEvent, error)
 }
 
 // BlockchainPackage serves as interface to the blockchain oriented activities, such as executing transactions, querying, and updating the ledger
@@ -74,6 +200,59 @@ type BlockchainPackage interface {
 	Executor
 	Ledger
 	RemoteLedgers
+	QueryLedger
+	Deliverer
+}
+
+// SeekPosition indicates where in the blockchain a Deliver stream should begin
+type SeekPosition int
+
+const (
+	// SeekOldest starts the stream at the oldest block the ledger retains
+	SeekOldest SeekPosition = iota
+	// SeekNewest starts the stream at the most recently committed block
+	SeekNewest
+	// SeekSpecified starts the stream at the block number passed as startBlock
+	SeekSpecified
+)
+
+// SeekWaitPolicy controls what happens when the requested range is not yet available
+type SeekWaitPolicy int
+
+const (
+	// BlockUntilReady holds the stream open and delivers blocks as they are committed
+	BlockUntilReady SeekWaitPolicy = iota
+	// FailIfNotReady returns an error immediately rather than waiting for future blocks
+	FailIfNotReady
+)
+
+// SeekBehavior bundles the starting position and wait semantics for a Deliver call
+type SeekBehavior struct {
+	Position SeekPosition
+	Wait     SeekWaitPolicy
+}
+
+// DeliverPolicy is consulted for every block about to be delivered to a registered
+// client, allowing a consensus plugin to enforce per-client access rules
+type DeliverPolicy interface {
+	Allow(clientID string, block *pb.Block) bool
+}
+
+// Deliverer allows external clients (SDKs, wallets, sidecar processes) to subscribe
+// to a stream of committed blocks without participating in consensus messaging
+type Deliverer interface {
+	// Deliver streams blocks [startBlock, stopBlock] according to seek. A stopBlock
+	// of ^uint64(0) means "stream indefinitely"
+	Deliver(startBlock uint64, stopBlock uint64, seek SeekBehavior) (<-chan *pb.DeliverResponse, error)
+
+	// RegisterDeliverClient notifies the Deliverer of a new client so that newly
+	// committed blocks are pushed to it, subject to policy, and returns the
+	// channel those blocks arrive on. The channel is closed if clientID ever
+	// falls too far behind to keep up with newly committed blocks.
+	RegisterDeliverClient(clientID string, policy DeliverPolicy) (<-chan *pb.DeliverResponse, error)
+
+	// UnregisterDeliverClient stops pushing blocks to a previously registered client
+	UnregisterDeliverClient(clientID string) error
 }
 
 // CPI (Consensus Programming Interface) is the set of
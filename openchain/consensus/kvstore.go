@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is returned by a KVStore's Get when key is absent
+var ErrNotFound = errors.New("consensus: key not found")
+
+// KVStore is a minimal ordered key/value store. It backs the query index
+// that QueryLedger implementations build on top of a block-oriented
+// ledger, so that index can be swapped between an in-memory map (the
+// default, used by tests) and a persistent store without touching the
+// indexing logic itself
+type KVStore interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// IteratePrefix calls fn, in ascending key order, for every key with
+	// the given prefix, stopping early if fn returns false
+	IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error
+}
+
+// memKVStore is the default in-memory KVStore implementation
+type memKVStore struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+// NewMemKVStore returns a KVStore backed by an in-memory map, suitable for
+// tests and for single-process deployments that don't need the query index
+// to survive a restart
+func NewMemKVStore() KVStore {
+	return &memKVStore{data: make(map[string][]byte)}
+}
+
+func (s *memKVStore) Get(key []byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *memKVStore) Put(key, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.data[string(key)] = value
+	return nil
+}
+
+func (s *memKVStore) Delete(key []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *memKVStore) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	s.mutex.RLock()
+	matched := make([]string, 0)
+	for key := range s.data {
+		if len(key) >= len(prefix) && key[:len(prefix)] == string(prefix) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Strings(matched)
+
+	values := make([][]byte, len(matched))
+	for i, key := range matched {
+		values[i] = s.data[key]
+	}
+	s.mutex.RUnlock()
+
+	for i, key := range matched {
+		if !fn([]byte(key), values[i]) {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package consensus
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// levelDBKVStore is a KVStore backed by an embedded LevelDB database, for
+// deployments that need the query index to persist across restarts and
+// scale past what's comfortable in memory
+type levelDBKVStore struct {
+	db *leveldb.DB
+}
+
+// NewLevelDBKVStore opens (creating if necessary) a LevelDB database at path
+// and returns it as a KVStore
+func NewLevelDBKVStore(path string) (KVStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if nil != err {
+		return nil, err
+	}
+	return &levelDBKVStore{db: db}, nil
+}
+
+func (s *levelDBKVStore) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if leveldb.ErrNotFound == err {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *levelDBKVStore) Put(key, value []byte) error {
+	return s.db.Put(key, value, nil)
+}
+
+func (s *levelDBKVStore) Delete(key []byte) error {
+	return s.db.Delete(key, nil)
+}
+
+func (s *levelDBKVStore) IteratePrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
@@ -0,0 +1,83 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package noops provides a Consenter that performs no consensus at all:
+// every block and every transaction is accepted immediately. It exists as
+// the minimal reference implementation of consensus.ConsensusFactory, and
+// as a single-node baseline for development and testing of the rest of the
+// stack without standing up a PBFT cluster.
+package noops
+
+import (
+	"context"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	pb "github.com/openblockchain/obc-peer/protos"
+)
+
+func init() {
+	consensus.Register("noops", New)
+}
+
+type noopsConsenter struct {
+	cpi consensus.CPI
+}
+
+// New constructs a no-op Consenter bound to cpi. It is registered under the
+// name "noops".
+func New(cpi consensus.CPI) consensus.Consenter {
+	return &noopsConsenter{cpi: cpi}
+}
+
+func (n *noopsConsenter) RecvMsg(msg *pb.OpenchainMessage) error {
+	return nil
+}
+
+func (n *noopsConsenter) ValidateBlock(block *pb.Block) error {
+	return nil
+}
+
+func (n *noopsConsenter) ValidateBlockHeader(block *pb.Block) error {
+	return nil
+}
+
+func (n *noopsConsenter) ValidateBlockPubsub(msg *pubsub.Message) pubsub.ValidationResult {
+	return pubsub.ValidationAccept
+}
+
+func (n *noopsConsenter) MinerCreateBlock(ctx context.Context, parent *pb.Block, txs []*pb.Transaction) (*pb.Block, error) {
+	parentHash, err := n.cpi.HashBlock(parent)
+	if nil != err {
+		return nil, err
+	}
+
+	return &pb.Block{
+		Transactions:      txs,
+		PreviousBlockHash: parentHash,
+	}, nil
+}
+
+func (n *noopsConsenter) Weight(chain []*pb.Block) (uint64, error) {
+	return uint64(len(chain)), nil
+}
+
+func (n *noopsConsenter) IsEpochBeyondCurr(epoch uint64) bool {
+	return false
+}
@@ -0,0 +1,138 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	pb "github.com/openblockchain/obc-peer/protos"
+)
+
+func init() {
+	consensus.Register("pbft", New)
+}
+
+// obcConsenter is the obcpbft package's Consenter implementation. It wraps a
+// CPI handle and provides the block validation, proposal, and weighing
+// behaviors every consensus plugin must implement. It does no PBFT message
+// handling: RecvMsg returns an error for every message.
+type obcConsenter struct {
+	cpi consensus.CPI
+}
+
+// New constructs a PBFT Consenter bound to cpi. It is registered under the
+// name "pbft" so peer bootstrap code can select it by configuration via
+// consensus.New(name, cpi). This snapshot of the repository has no peer
+// bootstrap entrypoint to wire that selection into; New/Register are that
+// hook, ready for such code once it exists.
+func New(cpi consensus.CPI) consensus.Consenter {
+	return &obcConsenter{cpi: cpi}
+}
+
+func (c *obcConsenter) RecvMsg(msg *pb.OpenchainMessage) error {
+	return fmt.Errorf("obcpbft: RecvMsg not implemented on obcConsenter")
+}
+
+// ValidateBlock checks block's header; PBFT carries no consensus-specific
+// block content beyond the header
+func (c *obcConsenter) ValidateBlock(block *pb.Block) error {
+	return c.ValidateBlockHeader(block)
+}
+
+// ValidateBlockHeader checks that block's PreviousBlockHash matches the hash
+// of the current chain head
+func (c *obcConsenter) ValidateBlockHeader(block *pb.Block) error {
+	height, err := c.cpi.GetBlockchainSize()
+	if nil != err {
+		return err
+	}
+	if 0 == height {
+		return nil
+	}
+
+	head, err := c.cpi.GetBlock(height - 1)
+	if nil != err {
+		return err
+	}
+
+	headHash, err := c.cpi.HashBlock(head)
+	if nil != err {
+		return err
+	}
+
+	if !bytes.Equal(headHash, block.PreviousBlockHash) {
+		return fmt.Errorf("block does not chain to the current head")
+	}
+
+	return nil
+}
+
+// ValidateBlockPubsub performs the header check from ValidateBlockHeader
+// against a block relayed over a gossip pubsub topic
+func (c *obcConsenter) ValidateBlockPubsub(msg *pubsub.Message) pubsub.ValidationResult {
+	block := &pb.Block{}
+	if err := proto.Unmarshal(msg.Data, block); nil != err {
+		return pubsub.ValidationReject
+	}
+
+	if err := c.ValidateBlockHeader(block); nil != err {
+		return pubsub.ValidationReject
+	}
+
+	return pubsub.ValidationAccept
+}
+
+// MinerCreateBlock assembles a candidate block extending parent with txs,
+// without committing it to the ledger
+func (c *obcConsenter) MinerCreateBlock(ctx context.Context, parent *pb.Block, txs []*pb.Transaction) (*pb.Block, error) {
+	parentHash, err := c.cpi.HashBlock(parent)
+	if nil != err {
+		return nil, err
+	}
+
+	stateHash, err := c.cpi.GetCurrentStateHash()
+	if nil != err {
+		return nil, err
+	}
+
+	return &pb.Block{
+		Transactions:      txs,
+		PreviousBlockHash: parentHash,
+		StateHash:         stateHash,
+	}, nil
+}
+
+// Weight reports chain length as the PBFT weight: PBFT is a crash/Byzantine
+// fault tolerant protocol with deterministic finality rather than a
+// fork-choice consensus, so a committed chain has no competing weight to
+// compare beyond its length
+func (c *obcConsenter) Weight(chain []*pb.Block) (uint64, error) {
+	return uint64(len(chain)), nil
+}
+
+// IsEpochBeyondCurr always reports false: PBFT has no epoch concept
+func (c *obcConsenter) IsEpochBeyondCurr(epoch uint64) bool {
+	return false
+}
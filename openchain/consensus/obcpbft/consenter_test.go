@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	pb "github.com/openblockchain/obc-peer/protos"
+)
+
+// testCPI adapts a MockLedger into a full consensus.CPI by stubbing the
+// peer-stack-facing methods that sit alongside BlockchainPackage and that
+// obcConsenter never calls in these tests
+type testCPI struct {
+	*MockLedger
+}
+
+func (t *testCPI) GetNetworkHandles() (string, []string, error) { return "self", nil, nil }
+func (t *testCPI) GetReplicaHandle(id uint64) (string, error)   { return "", nil }
+func (t *testCPI) GetReplicaID(handle string) (uint64, error)   { return 0, nil }
+func (t *testCPI) Broadcast(msg *pb.OpenchainMessage) error     { return nil }
+func (t *testCPI) Unicast(msg *pb.OpenchainMessage, receiverHandle string) error {
+	return nil
+}
+
+func newTestCPI() *testCPI {
+	return &testCPI{NewMockLedger(nil, nil)}
+}
+
+func TestRegisterPanicsOnDuplicateName(t *testing.T) {
+	name := "register-test-duplicate"
+	consensus.Register(name, New)
+
+	defer func() {
+		if r := recover(); nil == r {
+			t.Fatalf("Expected a second Register for %q to panic", name)
+		}
+	}()
+	consensus.Register(name, New)
+}
+
+func TestNewErrorsOnUnknownName(t *testing.T) {
+	if _, err := consensus.New("no-such-consensus-implementation", newTestCPI()); nil == err {
+		t.Fatalf("Expected New to error for an unregistered name")
+	}
+}
+
+func TestNewConstructsRegisteredPBFT(t *testing.T) {
+	consenter, err := consensus.New("pbft", newTestCPI())
+	if nil != err {
+		t.Fatalf("Error constructing the registered pbft consenter: %s", err)
+	}
+	if nil == consenter {
+		t.Fatalf("Expected a non-nil Consenter")
+	}
+}
+
+func TestObcConsenterValidateBlockHeader(t *testing.T) {
+	cpi := newTestCPI()
+	consenter := New(cpi)
+
+	genesis := SimpleGetBlock(0)
+	_ = cpi.PutBlock(0, genesis) // Never fails
+	headHash, err := cpi.HashBlock(genesis)
+	if nil != err {
+		t.Fatalf("Error hashing genesis block: %s", err)
+	}
+
+	goodBlock := &pb.Block{PreviousBlockHash: headHash}
+	if err := consenter.ValidateBlockHeader(goodBlock); nil != err {
+		t.Fatalf("Expected a correctly chained block header to validate: %s", err)
+	}
+
+	brokenBlock := &pb.Block{PreviousBlockHash: []byte("not-the-real-head-hash")}
+	if err := consenter.ValidateBlockHeader(brokenBlock); nil == err {
+		t.Fatalf("Expected a block with a broken chain link to fail validation")
+	}
+}
+
+func TestObcConsenterValidateBlockPubsub(t *testing.T) {
+	cpi := newTestCPI()
+	consenter := New(cpi)
+
+	genesis := SimpleGetBlock(0)
+	_ = cpi.PutBlock(0, genesis) // Never fails
+	headHash, err := cpi.HashBlock(genesis)
+	if nil != err {
+		t.Fatalf("Error hashing genesis block: %s", err)
+	}
+
+	goodBlock := &pb.Block{PreviousBlockHash: headHash}
+	data, err := proto.Marshal(goodBlock)
+	if nil != err {
+		t.Fatalf("Error marshaling block: %s", err)
+	}
+	if result := consenter.ValidateBlockPubsub(&pubsub.Message{Data: data}); pubsub.ValidationAccept != result {
+		t.Fatalf("Expected a well-formed, correctly chained block to be accepted, got %v", result)
+	}
+
+	malformed := &pubsub.Message{Data: []byte{0xFF, 0xFF, 0xFF}} // truncated varint, never a valid protobuf message
+	if result := consenter.ValidateBlockPubsub(malformed); pubsub.ValidationReject != result {
+		t.Fatalf("Expected a malformed pubsub message to be rejected, got %v", result)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	"github.com/openblockchain/obc-peer/protos"
+)
+
+// deliverClientBuffer bounds how far a registered client may lag behind the
+// commit path before it is dropped. It exists so a slow or disconnected
+// client can never block publish, and therefore can never block the
+// PutBlock/CommitTxBatch call that drives it.
+const deliverClientBuffer = 64
+
+// deliverClient tracks a single registered Deliver subscriber
+type deliverClient struct {
+	ch     chan *protos.DeliverResponse
+	policy consensus.DeliverPolicy
+}
+
+// deliverHub fans newly committed blocks out to every registered client, filtering
+// each block through that client's policy before it is pushed. Ledger
+// implementations embed a deliverHub and call publish from their commit path.
+type deliverHub struct {
+	mutex   sync.Mutex
+	clients map[string]*deliverClient
+}
+
+func newDeliverHub() *deliverHub {
+	return &deliverHub{
+		clients: make(map[string]*deliverClient),
+	}
+}
+
+// RegisterDeliverClient begins pushing newly committed blocks to clientID and
+// returns the channel they will arrive on. The channel is closed, and
+// clientID dropped, if the client ever falls more than deliverClientBuffer
+// blocks behind.
+func (h *deliverHub) RegisterDeliverClient(clientID string, policy consensus.DeliverPolicy) (<-chan *protos.DeliverResponse, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.clients[clientID]; ok {
+		return nil, fmt.Errorf("client %s is already registered", clientID)
+	}
+
+	client := &deliverClient{
+		ch:     make(chan *protos.DeliverResponse, deliverClientBuffer),
+		policy: policy,
+	}
+	h.clients[clientID] = client
+
+	return client.ch, nil
+}
+
+// UnregisterDeliverClient stops pushing blocks to clientID and closes its channel
+func (h *deliverHub) UnregisterDeliverClient(clientID string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	client, ok := h.clients[clientID]
+	if !ok {
+		return fmt.Errorf("client %s is not registered", clientID)
+	}
+
+	close(client.ch)
+	delete(h.clients, clientID)
+
+	return nil
+}
+
+// publish pushes block to every registered client whose policy allows it. A
+// client whose buffer is full is dropped (its channel closed, itself
+// unregistered) rather than stalling the publisher, so one inattentive
+// client can never halt block commitment for the whole ledger.
+func (h *deliverHub) publish(blockNumber uint64, block *protos.Block) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	resp := &protos.DeliverResponse{
+		BlockNumber: blockNumber,
+		Block:       block,
+	}
+
+	for clientID, client := range h.clients {
+		if client.policy != nil && !client.policy.Allow(clientID, block) {
+			continue
+		}
+
+		select {
+		case client.ch <- resp:
+		default:
+			close(client.ch)
+			delete(h.clients, clientID)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/openblockchain/obc-peer/protos"
+)
+
+func merkleHash(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// merkleTree is a simple binary Merkle tree over an ordered list of leaf
+// hashes. Odd nodes at a level are promoted unchanged rather than duplicated,
+// so a proof only ever records a sibling where one actually exists.
+type merkleTree struct {
+	levels [][][]byte // levels[0] is the leaves, levels[len-1] is {root}
+}
+
+func newMerkleTree(leaves [][]byte) *merkleTree {
+	if len(leaves) == 0 {
+		return &merkleTree{levels: [][][]byte{{merkleHash()}}}
+	}
+
+	level := make([][]byte, len(leaves))
+	copy(level, leaves)
+
+	tree := &merkleTree{levels: [][][]byte{level}}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleHash(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+		tree.levels = append(tree.levels, level)
+	}
+
+	return tree
+}
+
+func (t *merkleTree) root() []byte {
+	top := t.levels[len(t.levels)-1]
+	return top[0]
+}
+
+// proof returns the sibling hashes and left/right bitmap needed to fold the
+// leaf at index up to the root. appendFold can be used to extend the proof
+// with additional levels above the tree (see SimpleHashBlock).
+func (t *merkleTree) proof(index int) (*protos.MerkleProof, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := &protos.MerkleProof{}
+
+	for level := 0; level < len(t.levels)-1; level++ {
+		nodes := t.levels[level]
+		isLeft := index%2 == 0
+		siblingIndex := index + 1
+		if !isLeft {
+			siblingIndex = index - 1
+		}
+
+		if siblingIndex < len(nodes) {
+			appendFold(proof, nodes[siblingIndex], !isLeft)
+		}
+		// Otherwise this node was promoted unchanged, no sibling to record
+
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// appendFold records one more level in a Merkle proof: sibling is the hash
+// folded in at this level, and siblingOnLeft records whether it belongs to
+// the left (true) or right (false) of the running node when verifying.
+func appendFold(proof *protos.MerkleProof, sibling []byte, siblingOnLeft bool) {
+	idx := len(proof.Siblings)
+	proof.Siblings = append(proof.Siblings, sibling)
+
+	if len(proof.PathBitmap) <= idx/8 {
+		proof.PathBitmap = append(proof.PathBitmap, 0)
+	}
+	if siblingOnLeft {
+		proof.PathBitmap[idx/8] |= 1 << uint(idx%8)
+	}
+}
+
+// verifyMerkleProof recomputes the root by folding leaf up through proof's
+// siblings, in the order they were appended, and reports whether the result
+// matches root
+func verifyMerkleProof(root []byte, proof *protos.MerkleProof, leaf []byte) (bool, error) {
+	if nil == proof {
+		return false, fmt.Errorf("nil proof")
+	}
+
+	node := leaf
+	for i, sibling := range proof.Siblings {
+		if i/8 >= len(proof.PathBitmap) {
+			return false, fmt.Errorf("proof bitmap too short for %d siblings", len(proof.Siblings))
+		}
+
+		siblingOnLeft := proof.PathBitmap[i/8]&(1<<uint(i%8)) != 0
+		if siblingOnLeft {
+			node = merkleHash(sibling, node)
+		} else {
+			node = merkleHash(node, sibling)
+		}
+	}
+
+	return bytes.Equal(node, root), nil
+}
@@ -0,0 +1,230 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	"github.com/openblockchain/obc-peer/protos"
+)
+
+var (
+	txLocationPrefix  = []byte("txloc:")
+	blockHashPrefix   = []byte("blockhash:")
+	chaincodeTxPrefix = []byte("cctx:")
+)
+
+// encodeLocation packs a block number and transaction index into a fixed
+// width value suitable for storing in a KVStore
+func encodeLocation(blockNumber uint64, txIndex int) []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf[:8], blockNumber)
+	binary.BigEndian.PutUint32(buf[8:], uint32(txIndex))
+	return buf
+}
+
+func decodeLocation(value []byte) (blockNumber uint64, txIndex int, err error) {
+	if len(value) != 12 {
+		return 0, 0, fmt.Errorf("malformed query index entry")
+	}
+	return binary.BigEndian.Uint64(value[:8]), int(binary.BigEndian.Uint32(value[8:])), nil
+}
+
+// eventClientBuffer bounds how far a subscriber may lag behind committed
+// events before it is dropped. It exists so a slow or disconnected
+// SubscribeChaincodeEvents caller can never block publishEvent, and
+// therefore can never block the CommitTxBatch call that drives it.
+const eventClientBuffer = 64
+
+// chaincodeEventClient is a single SubscribeChaincodeEvents subscriber
+type chaincodeEventClient struct {
+	ch     chan *protos.ChaincodeEvent
+	filter consensus.EventFilter
+}
+
+// queryIndex maintains the lookup structures QueryLedger needs on top of a
+// block-oriented ledger: transaction and block-hash locations live in a
+// KVStore (swappable for a persistent implementation in production), while
+// transaction results and live chaincode event subscriptions stay in
+// memory, since neither needs to survive a restart the way the location
+// index does
+type queryIndex struct {
+	store consensus.KVStore
+
+	mutex            sync.Mutex
+	txResults        map[string]*protos.TransactionResult
+	eventClients     map[string][]*chaincodeEventClient
+	chaincodeTxCount map[string]uint64
+}
+
+// newQueryIndex returns a queryIndex backed by store, or by a fresh
+// in-memory KVStore if store is nil
+func newQueryIndex(store consensus.KVStore) *queryIndex {
+	if nil == store {
+		store = consensus.NewMemKVStore()
+	}
+	return &queryIndex{
+		store:            store,
+		txResults:        make(map[string]*protos.TransactionResult),
+		eventClients:     make(map[string][]*chaincodeEventClient),
+		chaincodeTxCount: make(map[string]uint64),
+	}
+}
+
+// indexBlock records blockNumber's block and transaction locations. It is
+// called once per committed block, from PutBlock.
+func (q *queryIndex) indexBlock(blockNumber uint64, block *protos.Block) {
+	blockHash := SimpleHashBlock(block)
+	q.store.Put(append(append([]byte{}, blockHashPrefix...), blockHash...), encodeLocation(blockNumber, -1))
+
+	for i, transaction := range block.Transactions {
+		if "" == transaction.Txid {
+			continue
+		}
+		q.store.Put(append(append([]byte{}, txLocationPrefix...), []byte(transaction.Txid)...), encodeLocation(blockNumber, i))
+		q.indexChaincodeTx(transaction.ChaincodeID, transaction.Txid)
+	}
+}
+
+// indexChaincodeTx appends txID to the end of chaincodeID's transaction
+// list, so ListTransactionsByChaincode can page through them in commit
+// order. The next index is tracked in memory per chaincode instead of being
+// recomputed by rescanning the store on every call, so indexing a
+// transaction stays O(1) regardless of how many transactions the chaincode
+// has already accumulated.
+func (q *queryIndex) indexChaincodeTx(chaincodeID []byte, txID string) {
+	if 0 == len(chaincodeID) {
+		return
+	}
+
+	key := string(chaincodeID)
+
+	q.mutex.Lock()
+	count := q.chaincodeTxCount[key]
+	q.chaincodeTxCount[key] = count + 1
+	q.mutex.Unlock()
+
+	prefix := chaincodeTxKeyPrefix(chaincodeID)
+	q.store.Put([]byte(fmt.Sprintf("%s%020d", prefix, count)), []byte(txID))
+}
+
+func chaincodeTxKeyPrefix(chaincodeID []byte) []byte {
+	return append(append(append([]byte{}, chaincodeTxPrefix...), chaincodeID...), ':')
+}
+
+// indexTransactionResults records txResults, keyed by transaction ID, and
+// publishes any attached chaincode events to live subscribers. It is called
+// alongside indexBlock whenever a batch commits.
+func (q *queryIndex) indexTransactionResults(txResults []*protos.TransactionResult) {
+	q.mutex.Lock()
+	for _, result := range txResults {
+		q.txResults[result.Txid] = result
+	}
+	q.mutex.Unlock()
+
+	for _, result := range txResults {
+		if nil != result.ChaincodeEvent {
+			q.publishEvent(result.ChaincodeEvent)
+		}
+	}
+}
+
+// publishEvent pushes event to every live subscriber for its chaincode ID
+// whose filter allows it. A subscriber whose buffer is full is dropped (its
+// channel closed, itself unregistered) rather than stalling the publisher,
+// so one inattentive SubscribeChaincodeEvents caller can never halt
+// CommitTxBatch for the whole ledger.
+func (q *queryIndex) publishEvent(event *protos.ChaincodeEvent) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	clients := q.eventClients[event.ChaincodeID]
+	live := clients[:0]
+	for _, client := range clients {
+		if nil != client.filter && !client.filter.Allow(event) {
+			live = append(live, client)
+			continue
+		}
+
+		select {
+		case client.ch <- event:
+			live = append(live, client)
+		default:
+			close(client.ch)
+		}
+	}
+	q.eventClients[event.ChaincodeID] = live
+}
+
+// subscribe registers a new chaincode event subscriber and returns its
+// delivery channel. The channel is closed, and the subscriber dropped, if it
+// ever falls more than eventClientBuffer events behind.
+func (q *queryIndex) subscribe(chaincodeID string, filter consensus.EventFilter) <-chan *protos.ChaincodeEvent {
+	client := &chaincodeEventClient{ch: make(chan *protos.ChaincodeEvent, eventClientBuffer), filter: filter}
+
+	q.mutex.Lock()
+	q.eventClients[chaincodeID] = append(q.eventClients[chaincodeID], client)
+	q.mutex.Unlock()
+
+	return client.ch
+}
+
+func (q *queryIndex) getTransactionLocation(txID string) (blockNumber uint64, txIndex int, err error) {
+	value, err := q.store.Get(append(append([]byte{}, txLocationPrefix...), []byte(txID)...))
+	if nil != err {
+		return 0, 0, err
+	}
+	return decodeLocation(value)
+}
+
+func (q *queryIndex) getBlockNumberByHash(hash []byte) (uint64, error) {
+	value, err := q.store.Get(append(append([]byte{}, blockHashPrefix...), hash...))
+	if nil != err {
+		return 0, err
+	}
+	blockNumber, _, err := decodeLocation(value)
+	return blockNumber, err
+}
+
+func (q *queryIndex) listTransactionIDsByChaincode(chaincodeID []byte, start, limit uint64) ([]string, error) {
+	prefix := chaincodeTxKeyPrefix(chaincodeID)
+
+	var txIDs []string
+	var i uint64
+	err := q.store.IteratePrefix(prefix, func(key, value []byte) bool {
+		if i >= start && (0 == limit || uint64(len(txIDs)) < limit) {
+			txIDs = append(txIDs, string(value))
+		}
+		i++
+		return 0 == limit || uint64(len(txIDs)) < limit
+	})
+	return txIDs, err
+}
+
+func (q *queryIndex) getTransactionResults(txID string) (*protos.TransactionResult, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	result, ok := q.txResults[txID]
+	return result, ok
+}
@@ -0,0 +1,171 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/openblockchain/obc-peer/openchain/consensus"
+	"github.com/openblockchain/obc-peer/protos"
+)
+
+// SnapshotReassembler drives a chunked state snapshot transfer against a
+// RemoteLedgers handle. It verifies each chunk against the manifest as it
+// arrives and remembers which chunks already verified, so a call to
+// FetchAll after a network drop only re-fetches the chunks still missing
+// rather than restarting the whole transfer.
+type SnapshotReassembler struct {
+	remote    consensus.RemoteLedgers
+	replicaID uint64
+	manifest  *protos.SnapshotManifest
+	chunks    [][]byte // nil until that chunk has been fetched and verified
+}
+
+// NewSnapshotReassembler fetches blockNumber's manifest from replicaID and
+// returns a reassembler ready to fetch and verify its chunks. trustedStateHash
+// is the StateHash of blockNumber as seen in a block header the caller
+// already trusts (e.g. from GetRemoteBlocks); the manifest is rejected if its
+// own StateHash does not match, so a malicious replica can't serve chunks
+// for a snapshot that doesn't correspond to the agreed-upon block.
+func NewSnapshotReassembler(remote consensus.RemoteLedgers, replicaID uint64, blockNumber uint64, trustedStateHash []byte) (*SnapshotReassembler, error) {
+	manifest, err := remote.GetStateSnapshotManifest(replicaID, blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	if !bytes.Equal(manifest.StateHash, trustedStateHash) {
+		return nil, fmt.Errorf("manifest's state hash does not match the trusted block header for block %d", blockNumber)
+	}
+
+	return &SnapshotReassembler{
+		remote:    remote,
+		replicaID: replicaID,
+		manifest:  manifest,
+		chunks:    make([][]byte, manifest.ChunkCount),
+	}, nil
+}
+
+// FetchAll fetches and verifies every chunk that is not already verified,
+// returning the first verification or transport error it hits. Calling
+// FetchAll again after an error only retries the chunks still missing.
+func (r *SnapshotReassembler) FetchAll() error {
+	for i := uint32(0); i < r.manifest.ChunkCount; i++ {
+		if nil != r.chunks[i] {
+			continue
+		}
+
+		chunk, err := r.remote.GetStateSnapshotChunk(r.replicaID, r.manifest.Root, i)
+		if nil != err {
+			return fmt.Errorf("fetching chunk %d: %s", i, err)
+		}
+
+		if !bytes.Equal(merkleHash(chunk), r.manifest.ChunkHashes[i]) {
+			return fmt.Errorf("chunk %d failed hash verification against the manifest", i)
+		}
+
+		r.chunks[i] = chunk
+	}
+
+	return nil
+}
+
+// Done reports whether every chunk has been fetched and verified
+func (r *SnapshotReassembler) Done() bool {
+	for _, chunk := range r.chunks {
+		if nil == chunk {
+			return false
+		}
+	}
+	return true
+}
+
+// Blob concatenates the verified chunks back into the original state blob.
+// It is only meaningful once Done reports true.
+func (r *SnapshotReassembler) Blob() []byte {
+	var blob []byte
+	for _, chunk := range r.chunks {
+		blob = append(blob, chunk...)
+	}
+	return blob
+}
+
+// encodeDelta prefixes delta with its length as a varint, so a stream of
+// concatenated deltas can be split back apart regardless of how large any
+// individual delta is; a fixed-width assumption breaks the moment two
+// deltas differ in size.
+func encodeDelta(delta []byte) []byte {
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(delta)))
+	return append(prefix[:n], delta...)
+}
+
+// splitDeltas splits blob back into the length-prefixed deltas encodeDelta
+// built it from
+func splitDeltas(blob []byte) ([][]byte, error) {
+	var deltas [][]byte
+	for 0 < len(blob) {
+		length, n := binary.Uvarint(blob)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed snapshot: could not decode a delta length prefix")
+		}
+		blob = blob[n:]
+
+		if uint64(len(blob)) < length {
+			return nil, fmt.Errorf("malformed snapshot: delta length prefix exceeds remaining blob")
+		}
+		deltas = append(deltas, blob[:length])
+		blob = blob[length:]
+	}
+	return deltas, nil
+}
+
+// Apply splits the fully-verified, reassembled snapshot back into deltas
+// and applies them to ledger one at a time. If any delta fails to apply,
+// every delta applied so far in this call is unapplied in reverse order, so
+// the ledger is never left holding a partial snapshot.
+func (r *SnapshotReassembler) Apply(ledger consensus.WritableLedger) error {
+	if !r.Done() {
+		return fmt.Errorf("snapshot is not fully fetched and verified")
+	}
+
+	if !bytes.Equal(r.manifest.Root, newMerkleTree(r.manifest.ChunkHashes).root()) {
+		return fmt.Errorf("manifest is internally inconsistent: root does not match its own chunk hashes")
+	}
+
+	deltas, err := splitDeltas(r.Blob())
+	if nil != err {
+		return err
+	}
+
+	applied := 0
+	for _, delta := range deltas {
+		if err := ledger.ApplyStateDelta(delta, false); nil != err {
+			for i := applied - 1; i >= 0; i-- {
+				ledger.ApplyStateDelta(deltas[i], true)
+			}
+			return fmt.Errorf("applying reassembled snapshot, rolled back %d deltas: %s", applied, err)
+		}
+		applied++
+	}
+
+	return nil
+}
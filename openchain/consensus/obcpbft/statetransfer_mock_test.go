@@ -24,8 +24,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/openblockchain/obc-peer/openchain/consensus"
 	"github.com/openblockchain/obc-peer/protos"
@@ -74,6 +76,58 @@ type MockLedger struct {
 	curBatch []*protos.Transaction
 
 	inst *instance // To support the ExecTX stuff
+
+	deliverHub *deliverHub
+
+	// kv is a simplified stand-in for a Merkle-Patricia state trie: it holds
+	// the same key/value pairs a real trie would, just without the radix
+	// structure, and is used only to compute state roots and serve
+	// GetStateProof. commonCommitTx writes each committed transaction into it
+	// automatically, so GetStateProof is serving the key/value writes a real
+	// commit actually made, not only keys a test injected via PutState.
+	//
+	// block.StateHash itself is NOT this trie's root: it remains the scalar
+	// mock.state hash below, which the snapshot manifests, fault injection
+	// and VerifyBlockchain in this file already depend on. kv's
+	// root is exposed separately via GetStateRoot.
+	//
+	// WARNING: unlike GetTransactionProof (verified against HashBlock, a
+	// value the caller obtained independently of the replica being proved
+	// against), kv's root is not folded into StateHash or any other
+	// committed header field. A caller that wants to "trust" a root can only
+	// get one by calling GetStateRoot on the very replica GetStateProof is
+	// about to be asked to prove against, so a dishonest replica can serve
+	// any root alongside a proof that matches it. GetStateProof is therefore
+	// NOT a light-client-safe primitive yet: it only proves internal
+	// consistency between one replica's current kv and its own current root,
+	// not that the root was ever part of an agreed-upon block. See
+	// TestStateProofDoesNotAuthenticateAgainstReplica.
+	kv map[string][]byte
+
+	// manifests remembers which blockNumber each snapshot manifest root was
+	// generated against, so GetStateSnapshotChunk can reconstruct the exact
+	// same blob even if the ledger has advanced since the manifest was built
+	manifests map[string]uint64
+
+	// faults, when set, is consulted ahead of the legacy filter for the
+	// richer per-request fault behaviors (flip-bits, fork, equivocate,
+	// truncate) that a plain Normal/Corrupt/Timeout filter cannot express
+	faults *FaultScheduler
+
+	// queryIndex serves the consensus.QueryLedger methods below; it is kept
+	// up to date from PutBlock and commonCommitTx and never consulted for
+	// anything ApplyStateDelta/RollbackTxBatch touch, since those only ever
+	// affect the scalar mock.state and a not-yet-committed curBatch
+	queryIndex *queryIndex
+}
+
+// NewMockLedgerWithFaults is NewMockLedger plus a FaultScheduler driving the
+// richer fault behaviors in faults.go, for tests exploring adversarial
+// conditions beyond the legacy filter's three coarse outcomes
+func NewMockLedgerWithFaults(remoteLedgers *map[uint64]consensus.ReadOnlyLedger, faults *FaultScheduler) *MockLedger {
+	mock := NewMockLedger(remoteLedgers, faults.Filter)
+	mock.faults = faults
+	return mock
 }
 
 func NewMockLedger(remoteLedgers *map[uint64]consensus.ReadOnlyLedger, filter func(request mockRequest, replicaID uint64) mockResponse) *MockLedger {
@@ -82,6 +136,10 @@ func NewMockLedger(remoteLedgers *map[uint64]consensus.ReadOnlyLedger, filter fu
 	mock.blocks = make(map[uint64]*protos.Block)
 	mock.state = 0
 	mock.blockHeight = 0
+	mock.deliverHub = newDeliverHub()
+	mock.kv = make(map[string][]byte)
+	mock.manifests = make(map[string]uint64)
+	mock.queryIndex = newQueryIndex(nil)
 
 	if nil == filter {
 		mock.filter = func(request mockRequest, replicaID uint64) mockResponse {
@@ -179,6 +237,15 @@ func (mock *MockLedger) commonCommitTx(id interface{}, txs []*protos.Transaction
 	} else {
 		fmt.Printf("Debug: Mock ledger is inserting block %d with hash %v\n", mock.blockHeight, SimpleHashBlock(block))
 		mock.PutBlock(mock.blockHeight, block)
+		mock.queryIndex.indexTransactionResults(txResults)
+
+		for i, transaction := range txs {
+			key := transaction.Txid
+			if "" == key {
+				key = fmt.Sprintf("block-%d-tx-%d", mock.blockHeight-1, i)
+			}
+			mock.PutState([]byte(key), transaction.Payload) // Never fails
+		}
 	}
 
 	return block, nil
@@ -221,8 +288,219 @@ func (mock *MockLedger) HashBlock(block *protos.Block) ([]byte, error) {
 	return SimpleHashBlock(block), nil
 }
 
+// GetTransactionProof returns a proof that the transaction at txIndex is
+// included in the block at blockNumber. The proof verifies against the
+// block's own hash, as returned by HashBlock.
+func (mock *MockLedger) GetTransactionProof(blockNumber uint64, txIndex int) (*protos.MerkleProof, error) {
+	block, err := mock.GetBlock(blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	if txIndex < 0 || txIndex >= len(block.Transactions) {
+		return nil, fmt.Errorf("transaction index %d out of range for block %d", txIndex, blockNumber)
+	}
+
+	tree := newMerkleTree(txLeaves(block))
+	proof, err := tree.proof(txIndex)
+	if nil != err {
+		return nil, err
+	}
+
+	appendFold(proof, block.StateHash, false)
+	appendFold(proof, block.ConsensusMetadata, false)
+
+	return proof, nil
+}
+
+// PutState records a key/value pair in the simplified state trie used to
+// serve GetStateProof. commonCommitTx calls this for every transaction in a
+// committed block, keyed by Txid (or a block/index-derived key for
+// transactions with no Txid); tests may also call it directly to simulate a
+// chaincode write that isn't going through a full commit.
+func (mock *MockLedger) PutState(key, value []byte) error {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	mock.kv[string(key)] = value
+	return nil
+}
+
+// stateTree rebuilds the Merkle tree over mock.kv's sorted keys. Rebuilding
+// on every call is fine at mock/test scale; a real trie would update
+// incrementally.
+func (mock *MockLedger) stateTree() (tree *merkleTree, sortedKeys []string) {
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+
+	sortedKeys = make([]string, 0, len(mock.kv))
+	for k := range mock.kv {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	leaves := make([][]byte, len(sortedKeys))
+	for i, k := range sortedKeys {
+		leaves[i] = merkleHash([]byte(k), mock.kv[k])
+	}
+
+	return newMerkleTree(leaves), sortedKeys
+}
+
+// GetStateRoot returns the root of the current state trie. NOT safe to treat
+// as a trusted header value: see the WARNING on the kv field above. A caller
+// that already trusts some other root obtained out of band (e.g. folded
+// into a real header field by a production ledger) should compare against
+// that, not call this method on the replica it is trying to verify.
+func (mock *MockLedger) GetStateRoot() []byte {
+	tree, _ := mock.stateTree()
+	return tree.root()
+}
+
+// GetStateProof returns a proof that key maps to its current value in the
+// state trie, along with that value. See the WARNING on the kv field above:
+// this only proves the value is consistent with a root obtained from this
+// same replica, not that the root was ever committed to an agreed-upon block.
+func (mock *MockLedger) GetStateProof(key []byte) (*protos.MerkleProof, []byte, error) {
+	tree, sortedKeys := mock.stateTree()
+
+	index := sort.SearchStrings(sortedKeys, string(key))
+	if index >= len(sortedKeys) || sortedKeys[index] != string(key) {
+		return nil, nil, fmt.Errorf("key %x not found in state", key)
+	}
+
+	mock.mutex.Lock()
+	value := mock.kv[sortedKeys[index]]
+	mock.mutex.Unlock()
+
+	proof, err := tree.proof(index)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	return proof, value, nil
+}
+
+// VerifyProof recomputes root by folding leaf up through proof's siblings
+// and reports whether the result matches root
+func (mock *MockLedger) VerifyProof(root []byte, proof *protos.MerkleProof, leaf []byte) (bool, error) {
+	return verifyMerkleProof(root, proof, leaf)
+}
+
+// GetTransactionByID returns the transaction indexed under txID and the
+// number of the block it was committed in
+func (mock *MockLedger) GetTransactionByID(txID string) (*protos.Transaction, uint64, error) {
+	blockNumber, txIndex, err := mock.queryIndex.getTransactionLocation(txID)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	block, err := mock.GetBlock(blockNumber)
+	if nil != err {
+		return nil, 0, err
+	}
+	if txIndex < 0 || txIndex >= len(block.Transactions) {
+		return nil, 0, fmt.Errorf("transaction %s indexed at block %d is out of range", txID, blockNumber)
+	}
+
+	return block.Transactions[txIndex], blockNumber, nil
+}
+
+// GetBlockByHash returns the block whose hash is hash
+func (mock *MockLedger) GetBlockByHash(hash []byte) (*protos.Block, error) {
+	blockNumber, err := mock.queryIndex.getBlockNumberByHash(hash)
+	if nil != err {
+		return nil, err
+	}
+	return mock.GetBlock(blockNumber)
+}
+
+// ListTransactionsByChaincode returns up to limit transactions invoking
+// chaincodeID, in commit order, skipping the first start matches
+func (mock *MockLedger) ListTransactionsByChaincode(chaincodeID string, start, limit uint64) ([]*protos.Transaction, error) {
+	txIDs, err := mock.queryIndex.listTransactionIDsByChaincode([]byte(chaincodeID), start, limit)
+	if nil != err {
+		return nil, err
+	}
+
+	txs := make([]*protos.Transaction, 0, len(txIDs))
+	for _, txID := range txIDs {
+		tx, _, err := mock.GetTransactionByID(txID)
+		if nil != err {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// GetTransactionResults returns the TransactionResult recorded for txID when
+// its batch was committed
+func (mock *MockLedger) GetTransactionResults(txID string) (*protos.TransactionResult, error) {
+	result, ok := mock.queryIndex.getTransactionResults(txID)
+	if !ok {
+		return nil, fmt.Errorf("no results recorded for transaction %s", txID)
+	}
+	return result, nil
+}
+
+// SubscribeChaincodeEvents streams ChaincodeEvents emitted by chaincodeID as
+// they are committed, restricted to those filter allows
+func (mock *MockLedger) SubscribeChaincodeEvents(chaincodeID string, filter consensus.EventFilter) (<-chan *protos.ChaincodeEvent, error) {
+	return mock.queryIndex.subscribe(chaincodeID, filter), nil
+}
+
 func (mock *MockLedger) GetRemoteBlocks(replicaID uint64, start, finish uint64) (<-chan *protos.SyncBlocks, error) {
 	res := make(chan *protos.SyncBlocks)
+
+	// A FaultScheduler, when attached, replaces the legacy filter's coarse
+	// "corrupt the middle block" behavior with faults applied via
+	// ApplyBlockFault (per-block: delay, fork, equivocate, flip-bits) and
+	// ApplyBlockSequenceFault (sequence-wide: reorder, truncate); a FaultDrop
+	// profile closes the stream immediately instead, as if the replica were
+	// unreachable.
+	if nil != mock.faults {
+		if profile, fire := mock.faults.profileFor(replicaID, SyncBlocks); fire && FaultDrop == profile.Kind {
+			close(res)
+			return res, nil
+		}
+
+		go func() {
+			var heights []uint64
+			var blocks []*protos.Block
+
+			current := start
+			for {
+				block, err := (*mock.remoteLedgers)[replicaID].GetBlock(current)
+				if nil != err {
+					break
+				}
+				heights = append(heights, current)
+				blocks = append(blocks, block)
+
+				if current == finish {
+					break
+				}
+				if start < finish {
+					current++
+				} else {
+					current--
+				}
+			}
+
+			blocks = mock.faults.ApplyBlockSequenceFault(replicaID, SyncBlocks, blocks)
+			for i, block := range blocks {
+				block = mock.faults.ApplyBlockFault(replicaID, SyncBlocks, heights[i], block)
+				res <- &protos.SyncBlocks{
+					Range:  &protos.SyncBlockRange{Start: heights[i], End: heights[i]},
+					Blocks: []*protos.Block{block},
+				}
+			}
+			close(res)
+		}()
+
+		return res, nil
+	}
+
 	ft := mock.filter(SyncBlocks, replicaID)
 	switch ft {
 	case Corrupt:
@@ -331,6 +609,135 @@ func (mock *MockLedger) GetRemoteStateSnapshot(replicaID uint64) (<-chan *protos
 	return res, nil
 }
 
+// snapshotBlob concatenates replica's transaction payloads for blocks
+// [0, blockNumber] into one contiguous byte blob, the same content
+// GetRemoteStateSnapshot has always streamed one delta at a time, just
+// reassembled so it can be split into fixed-size, content-addressed chunks.
+// Each payload is length-prefixed via encodeDelta so splitDeltas can recover
+// the original boundaries regardless of how large any one payload is.
+func snapshotBlob(remote consensus.ReadOnlyLedger, blockNumber uint64) ([]byte, error) {
+	var blob []byte
+	for i := uint64(0); i <= blockNumber; i++ {
+		block, err := remote.GetBlock(i)
+		if nil != err {
+			return nil, err
+		}
+		for _, transaction := range block.Transactions {
+			blob = append(blob, encodeDelta(transaction.Payload)...)
+		}
+	}
+	return blob, nil
+}
+
+// snapshotChunkSize is the fixed chunk size snapshots are split into
+const snapshotChunkSize = 4 * 1024 * 1024 // 4 MiB
+
+func chunksOf(blob []byte, chunkSize int) [][]byte {
+	chunks := [][]byte{}
+	for i := 0; i < len(blob); i += chunkSize {
+		end := i + chunkSize
+		if end > len(blob) {
+			end = len(blob)
+		}
+		chunks = append(chunks, blob[i:end])
+	}
+	if 0 == len(chunks) {
+		chunks = append(chunks, []byte{})
+	}
+	return chunks
+}
+
+// GetStateSnapshotManifest builds a manifest describing replicaID's state as
+// of blockNumber: a Merkle root over the hashes of its fixed-size chunks,
+// alongside the chunk hashes themselves and the target block/state root.
+func (mock *MockLedger) GetStateSnapshotManifest(replicaID uint64, blockNumber uint64) (*protos.SnapshotManifest, error) {
+	remote := (*mock.remoteLedgers)[replicaID]
+	if nil == remote {
+		return nil, fmt.Errorf("no remote ledger registered for replica %d", replicaID)
+	}
+
+	blob, err := snapshotBlob(remote, blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	chunks := chunksOf(blob, snapshotChunkSize)
+	chunkHashes := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		chunkHashes[i] = merkleHash(chunk)
+	}
+
+	targetBlock, err := remote.GetBlock(blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	root := newMerkleTree(chunkHashes).root()
+
+	mock.mutex.Lock()
+	mock.manifests[string(root)] = blockNumber
+	mock.mutex.Unlock()
+
+	return &protos.SnapshotManifest{
+		Root:        root,
+		ChunkCount:  uint32(len(chunks)),
+		ChunkHashes: chunkHashes,
+		BlockNumber: blockNumber,
+		StateHash:   targetBlock.StateHash,
+	}, nil
+}
+
+// GetStateSnapshotChunk fetches chunkIndex of the snapshot previously
+// described by a GetStateSnapshotManifest call that returned manifestRoot.
+// The SyncSnapshot filter entry is consulted per chunk, so fault-injecting
+// tests can corrupt or drop individual chunks to exercise resumable
+// transfer.
+func (mock *MockLedger) GetStateSnapshotChunk(replicaID uint64, manifestRoot []byte, chunkIndex uint32) ([]byte, error) {
+	mock.mutex.Lock()
+	blockNumber, ok := mock.manifests[string(manifestRoot)]
+	mock.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown manifest root")
+	}
+
+	remote := (*mock.remoteLedgers)[replicaID]
+	if nil == remote {
+		return nil, fmt.Errorf("no remote ledger registered for replica %d", replicaID)
+	}
+
+	// A FaultScheduler, when attached, replaces the legacy coarse filter for
+	// this request entirely, since mock.filter is already wired to
+	// faults.Filter and calling both would consume two inconsistent
+	// decisions for what should be a single logical call.
+	var legacyFault mockResponse
+	if nil == mock.faults {
+		legacyFault = mock.filter(SyncSnapshot, replicaID)
+		if Timeout == legacyFault {
+			return nil, fmt.Errorf("timed out fetching chunk %d from replica %d", chunkIndex, replicaID)
+		}
+	}
+
+	blob, err := snapshotBlob(remote, blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	chunks := chunksOf(blob, snapshotChunkSize)
+	if int(chunkIndex) >= len(chunks) {
+		return nil, fmt.Errorf("chunk index %d out of range, have %d chunks", chunkIndex, len(chunks))
+	}
+
+	if nil != mock.faults {
+		return mock.faults.ApplyChunkFault(replicaID, SyncSnapshot, chunks[chunkIndex])
+	}
+
+	if Corrupt == legacyFault {
+		return []byte("GARBAGE_CHUNK"), nil
+	}
+
+	return chunks[chunkIndex], nil
+}
+
 func (mock *MockLedger) GetRemoteStateDeltas(replicaID uint64, start, finish uint64) (<-chan *protos.SyncStateDeltas, error) {
 	res := make(chan *protos.SyncStateDeltas)
 	ft := mock.filter(SyncDeltas, replicaID)
@@ -400,9 +807,106 @@ func (mock *MockLedger) PutBlock(blockNumber uint64, block *protos.Block) error
 	if blockNumber >= mock.blockHeight {
 		mock.blockHeight = blockNumber + 1
 	}
+	mock.queryIndex.indexBlock(blockNumber, block)
+	mock.deliverHub.publish(blockNumber, block)
 	return nil
 }
 
+// RegisterDeliverClient begins pushing newly committed blocks to clientID
+func (mock *MockLedger) RegisterDeliverClient(clientID string, policy consensus.DeliverPolicy) (<-chan *protos.DeliverResponse, error) {
+	return mock.deliverHub.RegisterDeliverClient(clientID, policy)
+}
+
+// UnregisterDeliverClient stops pushing blocks to clientID
+func (mock *MockLedger) UnregisterDeliverClient(clientID string) error {
+	return mock.deliverHub.UnregisterDeliverClient(clientID)
+}
+
+// Deliver streams blocks [startBlock, stopBlock] according to seek, falling back
+// to the deliverHub to supply blocks committed after the stream catches up with
+// the current blockHeight
+func (mock *MockLedger) Deliver(startBlock uint64, stopBlock uint64, seek consensus.SeekBehavior) (<-chan *protos.DeliverResponse, error) {
+	mock.mutex.Lock()
+	height := mock.blockHeight
+	mock.mutex.Unlock()
+
+	switch seek.Position {
+	case consensus.SeekOldest:
+		startBlock = 0
+	case consensus.SeekNewest:
+		if height > 0 {
+			startBlock = height - 1
+		} else {
+			startBlock = 0
+		}
+	case consensus.SeekSpecified:
+		// startBlock is used as given
+	}
+
+	if startBlock >= height && seek.Wait == consensus.FailIfNotReady {
+		return nil, fmt.Errorf("requested start block %d is not yet available, current height %d", startBlock, height)
+	}
+
+	res := make(chan *protos.DeliverResponse)
+
+	go func() {
+		current := startBlock
+		for current < height && (stopBlock == ^uint64(0) || current <= stopBlock) {
+			block, err := mock.GetBlock(current)
+			if nil != err {
+				break
+			}
+			res <- &protos.DeliverResponse{
+				BlockNumber: current,
+				Block:       block,
+			}
+			current++
+		}
+
+		if seek.Wait != consensus.BlockUntilReady || (stopBlock != ^uint64(0) && current > stopBlock) {
+			close(res)
+			return
+		}
+
+		clientID := fmt.Sprintf("deliver-%p", res)
+		live, err := mock.RegisterDeliverClient(clientID, nil)
+		if nil != err {
+			close(res)
+			return
+		}
+
+		defer mock.UnregisterDeliverClient(clientID)
+
+		// Blocks committed before the client was registered never reach the
+		// hub, so they are read from the ledger before switching to live
+		height, _ = mock.GetBlockchainSize()
+		for ; current < height && (stopBlock == ^uint64(0) || current <= stopBlock); current++ {
+			block, err := mock.GetBlock(current)
+			if nil != err {
+				break
+			}
+			res <- &protos.DeliverResponse{BlockNumber: current, Block: block}
+		}
+		if stopBlock != ^uint64(0) && current > stopBlock {
+			close(res)
+			return
+		}
+
+		for resp := range live {
+			if resp.BlockNumber < current {
+				continue
+			}
+			res <- resp
+			if stopBlock != ^uint64(0) && resp.BlockNumber >= stopBlock {
+				break
+			}
+		}
+		close(res)
+	}()
+
+	return res, nil
+}
+
 func (mock *MockLedger) ApplyStateDelta(delta []byte, unapply bool) error {
 	mock.mutex.Lock()
 	defer func() {
@@ -503,20 +1007,108 @@ func (mock *MockRemoteLedger) GetCurrentStateHash() (stateHash []byte, err error
 	return SimpleEncodeUint64(SimpleGetState(mock.blockHeight - 1)), nil
 }
 
+// GetTransactionProof proves txIndex's inclusion in blockNumber's synthesized
+// block, the same way MockLedger.GetTransactionProof does for a committed one
+func (mock *MockRemoteLedger) GetTransactionProof(blockNumber uint64, txIndex int) (*protos.MerkleProof, error) {
+	block, err := mock.GetBlock(blockNumber)
+	if nil != err {
+		return nil, err
+	}
+
+	if txIndex < 0 || txIndex >= len(block.Transactions) {
+		return nil, fmt.Errorf("transaction index %d out of range for block %d", txIndex, blockNumber)
+	}
+
+	tree := newMerkleTree(txLeaves(block))
+	proof, err := tree.proof(txIndex)
+	if nil != err {
+		return nil, err
+	}
+
+	appendFold(proof, block.StateHash, false)
+	appendFold(proof, block.ConsensusMetadata, false)
+
+	return proof, nil
+}
+
+// syntheticState rebuilds the key/value state SimpleGetTransactions would
+// have produced for every block up to mock.blockHeight, using the same
+// block/index-derived key a committed transaction with no Txid gets in
+// MockLedger.commonCommitTx, so GetStateProof can serve a proof without a
+// MockRemoteLedger ever having stored anything stateful.
+func (mock *MockRemoteLedger) syntheticState() map[string][]byte {
+	kv := make(map[string][]byte)
+	for blockNumber := uint64(0); blockNumber < mock.blockHeight; blockNumber++ {
+		for i, transaction := range SimpleGetTransactions(blockNumber) {
+			key := transaction.Txid
+			if "" == key {
+				key = fmt.Sprintf("block-%d-tx-%d", blockNumber, i)
+			}
+			kv[key] = transaction.Payload
+		}
+	}
+	return kv
+}
+
+// GetStateProof proves key's value in the synthetic state every block up to
+// mock.blockHeight would have produced, the same way MockLedger.GetStateProof
+// does for its real, explicitly-populated kv. Carries the same
+// not-light-client-safe caveat as MockLedger.GetStateProof: see the WARNING
+// on MockLedger.kv.
+func (mock *MockRemoteLedger) GetStateProof(key []byte) (*protos.MerkleProof, []byte, error) {
+	kv := mock.syntheticState()
+
+	sortedKeys := make([]string, 0, len(kv))
+	for k := range kv {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	index := sort.SearchStrings(sortedKeys, string(key))
+	if index >= len(sortedKeys) || sortedKeys[index] != string(key) {
+		return nil, nil, fmt.Errorf("key %x not found in state", key)
+	}
+
+	leaves := make([][]byte, len(sortedKeys))
+	for i, k := range sortedKeys {
+		leaves[i] = merkleHash([]byte(k), kv[k])
+	}
+
+	proof, err := newMerkleTree(leaves).proof(index)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	return proof, kv[sortedKeys[index]], nil
+}
+
 func SimpleEncodeUint64(num uint64) []byte {
 	result := make([]byte, binary.MaxVarintLen64)
 	binary.PutUvarint(result, num)
 	return result
 }
 
+// SimpleHashBlock computes the block hash as a Merkle root over the block's
+// transactions, folded with the state hash and consensus metadata. This
+// folding order is what GetTransactionProof's proofs are built against, so
+// that a light client holding only a trusted block hash can verify a
+// transaction's inclusion via VerifyProof without the intervening levels
+// being computed separately.
 func SimpleHashBlock(block *protos.Block) []byte {
-	buffer := make([]byte, binary.MaxVarintLen64)
-	for _, transaction := range block.Transactions {
-		for i, b := range transaction.Payload {
-			buffer[i%binary.MaxVarintLen64] += b
-		}
+	withState := merkleHash(txTreeRoot(block), block.StateHash)
+	return merkleHash(withState, block.ConsensusMetadata)
+}
+
+func txLeaves(block *protos.Block) [][]byte {
+	leaves := make([][]byte, len(block.Transactions))
+	for i, transaction := range block.Transactions {
+		leaves[i] = merkleHash(transaction.Payload)
 	}
-	return []byte(fmt.Sprintf("BlockHash:%s-%s-%s", buffer, block.StateHash, block.ConsensusMetadata))
+	return leaves
+}
+
+func txTreeRoot(block *protos.Block) []byte {
+	return newMerkleTree(txLeaves(block)).root()
 }
 
 func SimpleGetState(blockNumber uint64) uint64 {
@@ -642,3 +1234,487 @@ func TestMockLedger(t *testing.T) {
 		t.Fatalf("Ledger state hash %s and block state hash %s do not match, error in mock ledger implementation", stateHash, block10.StateHash)
 	}
 }
+
+func TestMockLedgerDeliver(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	for i := uint64(0); i < 5; i++ {
+		_ = ml.PutBlock(i, SimpleGetBlock(i)) // Never fails
+	}
+
+	deliveries, err := ml.Deliver(0, 4, consensus.SeekBehavior{Position: consensus.SeekSpecified, Wait: consensus.FailIfNotReady})
+	if nil != err {
+		t.Fatalf("Deliver of an already committed range should not fail: %s", err)
+	}
+
+	count := uint64(0)
+	for resp := range deliveries {
+		if resp.BlockNumber != count {
+			t.Fatalf("Expected block %d but got %d", count, resp.BlockNumber)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Fatalf("Expected to deliver 5 blocks, got %d", count)
+	}
+
+	if _, err := ml.Deliver(10, 10, consensus.SeekBehavior{Position: consensus.SeekSpecified, Wait: consensus.FailIfNotReady}); nil == err {
+		t.Fatalf("Deliver of a future block with FailIfNotReady should fail")
+	}
+
+	live, err := ml.Deliver(5, ^uint64(0), consensus.SeekBehavior{Position: consensus.SeekSpecified, Wait: consensus.BlockUntilReady})
+	if nil != err {
+		t.Fatalf("Deliver with BlockUntilReady should not fail: %s", err)
+	}
+
+	_ = ml.PutBlock(5, SimpleGetBlock(5)) // Never fails
+
+	resp := <-live
+	if resp.BlockNumber != 5 {
+		t.Fatalf("Expected the newly committed block 5 to be delivered, got %d", resp.BlockNumber)
+	}
+}
+
+// TestMockRemoteLedgerProofs verifies that a MockRemoteLedger, which never
+// stores anything and only synthesizes blocks on demand, still serves
+// transaction and state proofs that verify against that synthesized data.
+func TestMockRemoteLedgerProofs(t *testing.T) {
+	rl := &MockRemoteLedger{3}
+
+	block, err := rl.GetBlock(1)
+	if nil != err {
+		t.Fatalf("Error fetching synthesized block: %s", err)
+	}
+
+	txProof, err := rl.GetTransactionProof(1, 0)
+	if nil != err {
+		t.Fatalf("Error getting transaction proof: %s", err)
+	}
+
+	blockHash := SimpleHashBlock(block)
+	leaf := merkleHash(block.Transactions[0].Payload)
+	ok, err := verifyMerkleProof(blockHash, txProof, leaf)
+	if nil != err {
+		t.Fatalf("Error verifying transaction proof: %s", err)
+	}
+	if !ok {
+		t.Fatalf("Failed to verify a transaction proof MockRemoteLedger should have been able to serve")
+	}
+
+	key := "block-1-tx-0"
+	stateProof, value, err := rl.GetStateProof([]byte(key))
+	if nil != err {
+		t.Fatalf("Error getting state proof: %s", err)
+	}
+	if !bytes.Equal(value, block.Transactions[0].Payload) {
+		t.Fatalf("Expected state proof to return the transaction's payload, got %x", value)
+	}
+
+	sortedKeys := make([]string, 0)
+	for k := range rl.syntheticState() {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+	leaves := make([][]byte, len(sortedKeys))
+	kv := rl.syntheticState()
+	for i, k := range sortedKeys {
+		leaves[i] = merkleHash([]byte(k), kv[k])
+	}
+	trustedRoot := newMerkleTree(leaves).root()
+
+	ok, err = verifyMerkleProof(trustedRoot, stateProof, merkleHash([]byte(key), value))
+	if nil != err {
+		t.Fatalf("Error verifying state proof: %s", err)
+	}
+	if !ok {
+		t.Fatalf("Failed to verify a state proof MockRemoteLedger should have been able to serve")
+	}
+}
+
+// TestMockLedgerDeliverSlowClientDropped proves that a registered Deliver
+// client which never drains its channel is dropped once it falls
+// deliverClientBuffer blocks behind, rather than stalling PutBlock for the
+// whole ledger.
+func TestMockLedgerDeliverSlowClientDropped(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	live, err := ml.RegisterDeliverClient("slow-client", nil)
+	if nil != err {
+		t.Fatalf("Error registering deliver client: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := uint64(0); i < deliverClientBuffer+10; i++ {
+			_ = ml.PutBlock(i, SimpleGetBlock(i)) // Never fails
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("PutBlock stalled on a client that never drained its channel")
+	}
+
+	// The client was dropped once its buffer filled, so its channel must have
+	// been closed; draining it should terminate rather than block forever.
+	for range live {
+	}
+}
+
+// TestMockLedgerLightClientTransactionProof exercises a light client that
+// holds only a block's hash, and verifies a transaction via a Merkle proof
+// without ever calling GetBlock for the full block. trustedBlockHash comes
+// from HashBlock on a block the client obtained independently of whichever
+// replica later serves the proof, so a dishonest replica cannot substitute a
+// forged transaction and a matching proof: the proof is checked against a
+// hash the client already committed to before asking.
+func TestMockLedgerLightClientTransactionProof(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	block := SimpleGetBlock(0)
+	block.Transactions = append(block.Transactions, &protos.Transaction{Payload: []byte("second-tx")})
+	_ = ml.PutBlock(0, block) // Never fails
+
+	trustedBlockHash, err := ml.HashBlock(block)
+	if nil != err {
+		t.Fatalf("Error hashing block: %s", err)
+	}
+
+	proof, err := ml.GetTransactionProof(0, 1)
+	if nil != err {
+		t.Fatalf("Error getting transaction proof: %s", err)
+	}
+
+	leaf := merkleHash(block.Transactions[1].Payload)
+	ok, err := ml.VerifyProof(trustedBlockHash, proof, leaf)
+	if nil != err {
+		t.Fatalf("Error verifying transaction proof: %s", err)
+	}
+	if !ok {
+		t.Fatalf("Light client failed to verify a transaction it should have been able to prove")
+	}
+}
+
+// TestMockLedgerStateProofInternalConsistency exercises GetStateProof/
+// GetStateRoot's actual guarantee: a proof is internally consistent with a
+// root obtained from the same replica. This is NOT a light-client guarantee
+// - see the WARNING on MockLedger.kv - because trustedStateRoot here is
+// fetched from ml itself, the very replica GetStateProof is about to be
+// asked to prove against, rather than from a header the caller already
+// trusted independently of the replica.
+func TestMockLedgerStateProofInternalConsistency(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	_ = ml.PutState([]byte("alice"), []byte("100")) // Never fails
+	_ = ml.PutState([]byte("bob"), []byte("200"))   // Never fails
+
+	trustedStateRoot := ml.GetStateRoot()
+
+	stateProof, value, err := ml.GetStateProof([]byte("alice"))
+	if nil != err {
+		t.Fatalf("Error getting state proof: %s", err)
+	}
+	if !bytes.Equal(value, []byte("100")) {
+		t.Fatalf("Expected state proof to return alice's value, got %s", value)
+	}
+
+	ok, err := ml.VerifyProof(trustedStateRoot, stateProof, merkleHash([]byte("alice"), value))
+	if nil != err {
+		t.Fatalf("Error verifying state proof: %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected the state proof to verify against this replica's own reported root")
+	}
+}
+
+// TestStateProofDoesNotAuthenticateAgainstReplica demonstrates the gap
+// TestMockLedgerStateProofInternalConsistency's guarantee leaves open: a
+// dishonest replica can forge a key's value and still have its own
+// self-reported root verify, because nothing binds that root to a header
+// value the caller trusted before asking this replica. A real light client
+// must not treat GetStateRoot as trustworthy input to VerifyProof.
+func TestStateProofDoesNotAuthenticateAgainstReplica(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	honest := NewMockLedger(&remoteLedgers, nil)
+	_ = honest.PutState([]byte("alice"), []byte("100")) // Never fails
+
+	dishonest := NewMockLedger(&remoteLedgers, nil)
+	_ = dishonest.PutState([]byte("alice"), []byte("FORGED")) // Never fails
+
+	// A light client holding only honest's root has no way to detect that
+	// dishonest is lying, because the "trusted" root it would compare
+	// against can only be obtained by asking a replica - including
+	// dishonest itself.
+	forgedRoot := dishonest.GetStateRoot()
+
+	forgedProof, forgedValue, err := dishonest.GetStateProof([]byte("alice"))
+	if nil != err {
+		t.Fatalf("Error getting forged state proof: %s", err)
+	}
+	if !bytes.Equal(forgedValue, []byte("FORGED")) {
+		t.Fatalf("Expected the dishonest replica to report its forged value, got %s", forgedValue)
+	}
+
+	ok, err := dishonest.VerifyProof(forgedRoot, forgedProof, merkleHash([]byte("alice"), forgedValue))
+	if nil != err {
+		t.Fatalf("Error verifying forged state proof: %s", err)
+	}
+	if !ok {
+		t.Fatalf("Expected the forged proof to verify against the dishonest replica's own root, demonstrating GetStateProof is not light-client-safe")
+	}
+}
+
+// TestMockLedgerQueryIndexConsistency interleaves PutBlock, RollbackTxBatch,
+// and an unrelated ApplyStateDelta unapply with a committed batch, and
+// verifies the query index reflects exactly the one committed transaction
+// regardless of the unrelated operations interleaved around it.
+func TestMockLedgerQueryIndexConsistency(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	// A batch that never commits must not leave any trace in the index
+	_ = ml.BeginTxBatch("abandoned") // Never fails
+	_ = ml.RollbackTxBatch("abandoned")
+
+	tx := &protos.Transaction{Txid: "tx-0", ChaincodeID: []byte("example02"), Payload: SimpleGetStateDelta(0)}
+	_ = ml.BeginTxBatch("batch-0") // Never fails
+	ml.curBatch = []*protos.Transaction{tx}
+	txResults := []*protos.TransactionResult{{Txid: "tx-0"}}
+	if err := ml.CommitTxBatch("batch-0", []*protos.Transaction{tx}, txResults, nil); nil != err {
+		t.Fatalf("Error committing batch: %s", err)
+	}
+
+	// An unapply of an unrelated delta must not disturb the index the
+	// commit above just built
+	_ = ml.ApplyStateDelta(SimpleGetStateDelta(0), true) // Never fails
+
+	gotTx, blockNumber, err := ml.GetTransactionByID("tx-0")
+	if nil != err {
+		t.Fatalf("Error looking up transaction by ID: %s", err)
+	}
+	if blockNumber != 0 {
+		t.Fatalf("Expected tx-0 to be indexed at block 0, got %d", blockNumber)
+	}
+	if gotTx.Txid != "tx-0" {
+		t.Fatalf("Indexed transaction does not match what was committed")
+	}
+
+	block0, _ := ml.GetBlock(0)
+	byHash, err := ml.GetBlockByHash(SimpleHashBlock(block0))
+	if nil != err {
+		t.Fatalf("Error looking up block by hash: %s", err)
+	}
+	if byHash.Transactions[0].Txid != "tx-0" {
+		t.Fatalf("Block looked up by hash does not match what was committed")
+	}
+
+	byChaincode, err := ml.ListTransactionsByChaincode("example02", 0, 0)
+	if nil != err {
+		t.Fatalf("Error listing transactions by chaincode: %s", err)
+	}
+	if len(byChaincode) != 1 || byChaincode[0].Txid != "tx-0" {
+		t.Fatalf("Expected exactly tx-0 to be indexed under chaincode example02, got %v", byChaincode)
+	}
+
+	results, err := ml.GetTransactionResults("tx-0")
+	if nil != err {
+		t.Fatalf("Error fetching transaction results: %s", err)
+	}
+	if results.Txid != "tx-0" {
+		t.Fatalf("Fetched the wrong transaction's results")
+	}
+}
+
+// TestMockLedgerQueryIndexMultipleTransactions commits several transactions
+// against the same chaincode across multiple blocks, and verifies
+// ListTransactionsByChaincode returns all of them in commit order. This
+// exercises indexChaincodeTx's per-chaincode sequence counter past the
+// single-transaction case TestMockLedgerQueryIndexConsistency covers.
+func TestMockLedgerQueryIndexMultipleTransactions(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	const chaincodeID = "example02"
+	for i := uint64(0); i < 5; i++ {
+		txid := fmt.Sprintf("tx-%d", i)
+		tx := &protos.Transaction{Txid: txid, ChaincodeID: []byte(chaincodeID), Payload: SimpleGetStateDelta(i)}
+		batchID := fmt.Sprintf("batch-%d", i)
+		_ = ml.BeginTxBatch(batchID) // Never fails
+		ml.curBatch = []*protos.Transaction{tx}
+		txResults := []*protos.TransactionResult{{Txid: txid}}
+		if err := ml.CommitTxBatch(batchID, []*protos.Transaction{tx}, txResults, nil); nil != err {
+			t.Fatalf("Error committing batch %d: %s", i, err)
+		}
+	}
+
+	byChaincode, err := ml.ListTransactionsByChaincode(chaincodeID, 0, 0)
+	if nil != err {
+		t.Fatalf("Error listing transactions by chaincode: %s", err)
+	}
+	if len(byChaincode) != 5 {
+		t.Fatalf("Expected 5 transactions indexed under %s, got %d", chaincodeID, len(byChaincode))
+	}
+	for i, tx := range byChaincode {
+		expected := fmt.Sprintf("tx-%d", i)
+		if tx.Txid != expected {
+			t.Fatalf("Expected transaction %d to be %s in commit order, got %s", i, expected, tx.Txid)
+		}
+	}
+}
+
+// TestMockLedgerSnapshotResumable drives a chunked snapshot transfer where
+// the first chunk fails verification on the first attempt, then verifies
+// that a retry on the same reassembler completes the transfer and that the
+// reassembled snapshot applies to produce the same state as the source.
+func TestMockLedgerSnapshotResumable(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	source := NewMockLedger(nil, nil)
+	remoteLedgers[0] = source
+
+	for i := uint64(0); i < 5; i++ {
+		block := SimpleGetBlock(i)
+		_ = source.PutBlock(i, block) // Never fails
+		for _, transaction := range block.Transactions {
+			_ = source.ApplyStateDelta(transaction.Payload, false) // Never fails
+		}
+	}
+
+	chunkZeroAttempts := 0
+	filter := func(request mockRequest, replicaID uint64) mockResponse {
+		if SyncSnapshot != request {
+			return Normal
+		}
+		chunkZeroAttempts++
+		if chunkZeroAttempts == 1 {
+			return Corrupt
+		}
+		return Normal
+	}
+
+	ml := NewMockLedger(&remoteLedgers, filter)
+
+	sourceBlock, err := source.GetBlock(4)
+	if nil != err {
+		t.Fatalf("Error fetching source block: %s", err)
+	}
+
+	reassembler, err := NewSnapshotReassembler(ml, 0, 4, sourceBlock.StateHash)
+	if nil != err {
+		t.Fatalf("Error constructing reassembler: %s", err)
+	}
+
+	if err := reassembler.FetchAll(); nil == err {
+		t.Fatalf("Expected the first fetch to fail due to a corrupted chunk")
+	}
+	if reassembler.Done() {
+		t.Fatalf("Reassembler should not report done after a failed fetch")
+	}
+
+	if err := reassembler.FetchAll(); nil != err {
+		t.Fatalf("Retry should have succeeded once the filter stopped corrupting chunks: %s", err)
+	}
+	if !reassembler.Done() {
+		t.Fatalf("Reassembler should report done once every chunk has verified")
+	}
+
+	target := NewMockLedger(nil, nil)
+	if err := reassembler.Apply(target); nil != err {
+		t.Fatalf("Error applying reassembled snapshot: %s", err)
+	}
+
+	targetStateHash, _ := target.GetCurrentStateHash()
+	sourceStateHash, _ := source.GetCurrentStateHash()
+	if !bytes.Equal(targetStateHash, sourceStateHash) {
+		t.Fatalf("Applied snapshot state %s does not match source state %s", targetStateHash, sourceStateHash)
+	}
+}
+
+// TestQueryIndexSlowSubscriberDropped proves a SubscribeChaincodeEvents
+// caller that never drains its channel is dropped once its buffer fills,
+// rather than stalling CommitTxBatch.
+func TestQueryIndexSlowSubscriberDropped(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	live, err := ml.SubscribeChaincodeEvents("example02", nil)
+	if nil != err {
+		t.Fatalf("Error subscribing to chaincode events: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventClientBuffer+10; i++ {
+			txid := fmt.Sprintf("tx-%d", i)
+			tx := &protos.Transaction{Txid: txid, ChaincodeID: []byte("example02"), Payload: SimpleGetStateDelta(uint64(i))}
+			txResults := []*protos.TransactionResult{{Txid: txid, ChaincodeEvent: &protos.ChaincodeEvent{ChaincodeID: "example02"}}}
+
+			_ = ml.BeginTxBatch(txid) // Never fails
+			ml.curBatch = []*protos.Transaction{tx}
+			if err := ml.CommitTxBatch(txid, []*protos.Transaction{tx}, txResults, nil); nil != err {
+				t.Errorf("Error committing batch %d: %s", i, err)
+				return
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("CommitTxBatch stalled on a subscriber that never drained its channel")
+	}
+
+	// The subscriber was dropped once its buffer filled, so its channel must
+	// have been closed; draining it should terminate rather than block forever.
+	for range live {
+	}
+}
+
+// TestSnapshotReassemblerNonUniformPayloads proves the chunked-snapshot round
+// trip survives transaction payloads of different sizes, rather than
+// assuming every delta is MaxVarintLen64 bytes wide.
+func TestSnapshotReassemblerNonUniformPayloads(t *testing.T) {
+	remoteLedgers := make(map[uint64]consensus.ReadOnlyLedger)
+	source := NewMockLedger(nil, nil)
+	remoteLedgers[0] = source
+
+	block0 := &protos.Block{
+		Transactions: []*protos.Transaction{
+			{Payload: []byte{5}},              // a 1-byte varint delta
+			{Payload: SimpleGetStateDelta(1)}, // a MaxVarintLen64-byte varint delta
+		},
+		PreviousBlockHash: []byte("Genesis"),
+	}
+	for _, transaction := range block0.Transactions {
+		_ = source.ApplyStateDelta(transaction.Payload, false) // Never fails
+	}
+	stateHash, _ := source.GetCurrentStateHash()
+	block0.StateHash = stateHash
+	_ = source.PutBlock(0, block0) // Never fails
+
+	ml := NewMockLedger(&remoteLedgers, nil)
+
+	reassembler, err := NewSnapshotReassembler(ml, 0, 0, stateHash)
+	if nil != err {
+		t.Fatalf("Error constructing reassembler: %s", err)
+	}
+	if err := reassembler.FetchAll(); nil != err {
+		t.Fatalf("Error fetching snapshot: %s", err)
+	}
+
+	target := NewMockLedger(nil, nil)
+	if err := reassembler.Apply(target); nil != err {
+		t.Fatalf("Error applying reassembled snapshot with non-uniform payload sizes: %s", err)
+	}
+
+	targetStateHash, _ := target.GetCurrentStateHash()
+	if !bytes.Equal(targetStateHash, stateHash) {
+		t.Fatalf("Applied snapshot state %s does not match source state %s", targetStateHash, stateHash)
+	}
+}